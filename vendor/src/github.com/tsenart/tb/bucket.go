@@ -0,0 +1,174 @@
+package tb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPollInterval is how often WaitN rechecks the bucket for available
+// tokens while blocked.
+const defaultPollInterval = 10 * time.Millisecond
+
+// Bucket represents a token bucket that holds up to capacity tokens, which
+// are thread-safely handed out on Take calls and reclaimed on Put calls.
+//
+// A Bucket is usually created and filled by a Throttler, but can also be
+// used standalone by callers who want to manage filling it themselves.
+type Bucket struct {
+	tokens    int64
+	capacity  int64
+	inc       int64
+	touched   int64
+	closed    int32
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+// NewBucket returns a new token bucket with the specified capacity which is
+// filled, when owned by a Throttler, at the rate specified by inc.
+func NewBucket(capacity, inc int64) *Bucket {
+	return &Bucket{
+		capacity: capacity,
+		inc:      inc,
+		touched:  time.Now().UnixNano(),
+		closing:  make(chan struct{}),
+	}
+}
+
+// Take takes n tokens from the bucket. Returns the number of tokens removed
+// from the bucket, which is less than n if the bucket doesn't hold enough.
+func (b *Bucket) Take(n int64) (taken int64) {
+	if atomic.LoadInt32(&b.closed) == 1 {
+		return 0
+	}
+
+	defer b.touch()
+
+	for {
+		cur := atomic.LoadInt64(&b.tokens)
+		taken = min(cur, n)
+		updated := cur - taken
+
+		if atomic.CompareAndSwapInt64(&b.tokens, cur, updated) {
+			return taken
+		}
+	}
+}
+
+// Put adds n tokens to the bucket, up to its capacity. It returns the number
+// of tokens actually added, which is less than n if the bucket is near
+// capacity.
+func (b *Bucket) Put(n int64) (put int64) {
+	defer b.touch()
+	return b.add(n)
+}
+
+// add adds n tokens to the bucket, up to its capacity, without touching it.
+// It's used by the Throttler's fill go-routine, whose periodic refills
+// aren't caller activity and so must not keep an otherwise-idle bucket from
+// being evicted under WithIdleTTL.
+func (b *Bucket) add(n int64) (put int64) {
+	if atomic.LoadInt32(&b.closed) == 1 {
+		return 0
+	}
+
+	for {
+		cur := atomic.LoadInt64(&b.tokens)
+		put = min(atomic.LoadInt64(&b.capacity)-cur, n)
+		updated := cur + put
+
+		if atomic.CompareAndSwapInt64(&b.tokens, cur, updated) {
+			return put
+		}
+	}
+}
+
+// Capacity returns the maximum number of tokens the bucket can hold.
+func (b *Bucket) Capacity() int64 {
+	return atomic.LoadInt64(&b.capacity)
+}
+
+// resize atomically updates the bucket's capacity and per-tick fill
+// increment, letting its rate change without tearing down and recreating it.
+// If capacity shrinks below the current token count, tokens are clamped
+// down to it right after the new capacity is stored, so the bucket doesn't
+// stay over capacity, which would otherwise make every later Put compute a
+// negative "added" count.
+func (b *Bucket) resize(capacity, inc int64) {
+	atomic.StoreInt64(&b.capacity, capacity)
+	atomic.StoreInt64(&b.inc, inc)
+
+	for {
+		cur := atomic.LoadInt64(&b.tokens)
+		if cur <= capacity {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&b.tokens, cur, capacity) {
+			return
+		}
+	}
+}
+
+// touch records that the bucket was just taken from or put into, so the
+// Throttler's fill go-routine can evict it once it's been idle longer than
+// its configured idle TTL.
+func (b *Bucket) touch() {
+	atomic.StoreInt64(&b.touched, time.Now().UnixNano())
+}
+
+// idleSince returns how long it's been since the bucket was last taken from
+// or put into.
+func (b *Bucket) idleSince(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&b.touched)))
+}
+
+// WaitN blocks until n tokens can be taken from the bucket, polling it at
+// defaultPollInterval, or until ctx is done. It returns ctx.Err() if ctx is
+// cancelled or its deadline expires first, putting back any tokens already
+// taken so they aren't lost.
+func (b *Bucket) WaitN(ctx context.Context, n int64) error {
+	got := b.Take(n)
+	if got == n {
+		return nil
+	}
+
+	timer := time.NewTimer(defaultPollInterval)
+	defer timer.Stop()
+
+	for got < n {
+		select {
+		case <-ctx.Done():
+			b.Put(got)
+			return ctx.Err()
+		case <-timer.C:
+			got += b.Take(n - got)
+			timer.Reset(defaultPollInterval)
+		}
+	}
+
+	return nil
+}
+
+// Close renders the bucket unusable for future calls to Take and Put, both
+// of which become no-ops returning 0. It's safe to call multiple times;
+// subsequent calls return ErrAlreadyClosed.
+func (b *Bucket) Close() error {
+	err := ErrAlreadyClosed
+
+	b.closeOnce.Do(func() {
+		err = nil
+		atomic.StoreInt32(&b.closed, 1)
+		close(b.closing)
+	})
+
+	return err
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
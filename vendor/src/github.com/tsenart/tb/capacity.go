@@ -0,0 +1,73 @@
+package tb
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// tolerance is the maximum fraction by which the worst-case burst rate
+// ChooseCapacity allows over rateHz.
+const tolerance = 0.05
+
+// ErrRateTooLow is returned by ChooseCapacity and NewBucketForRate when
+// rateHz is too low for the requested window to satisfy tolerance at any
+// capacity, including the smallest one of 1.
+var ErrRateTooLow = errors.New("tb: rate too low for the requested window")
+
+// ChooseCapacity picks the largest token bucket capacity that keeps the
+// long-run rate within tolerance of rateHz over any sliding window of the
+// given duration, letting callers reason about burstiness versus smoothing
+// instead of picking a capacity by hand.
+//
+// It simulates the worst case for a candidate capacity C: the bucket starts
+// full, is drained instantly, then refills at rateHz tokens/sec, so the
+// tokens dispensed over window equal C + floor(rateHz*window), against the
+// floor(rateHz*window) dispensed in the steady state. That worst-case rate,
+// (C+floor(rateHz*window))/window, increases monotonically with C, so it
+// only ever crosses the rateHz*(1+tolerance) ceiling once: the *smallest* C
+// satisfying it is the degenerate C=1 (or 0), which wastes all the burst
+// headroom the tolerance allows for. ChooseCapacity instead binary-searches
+// C in [1, ceil(rateHz*window)*4] for the *largest* value whose worst-case
+// rate still stays at or below the ceiling, which is the only choice that
+// actually uses the tolerance to buy burst capacity.
+func ChooseCapacity(rateHz float64, window time.Duration) (capacity int64, err error) {
+	seconds := window.Seconds()
+	steady := math.Floor(rateHz * seconds)
+	limit := rateHz * (1 + tolerance)
+
+	fits := func(c int64) bool {
+		return (float64(c)+steady)/seconds <= limit
+	}
+
+	lo, hi := int64(1), int64(math.Ceil(rateHz*seconds))*4
+	if !fits(lo) {
+		return 0, ErrRateTooLow
+	}
+
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return lo, nil
+}
+
+// NewBucketForRate returns a Bucket whose capacity is chosen by
+// ChooseCapacity for rateHz over window. It's a building block for callers
+// who drive their own fill loop; the returned Bucket isn't filled by
+// anything yet. Most callers want Throttler.AddBucketForRate instead, which
+// registers an equivalently-sized Bucket that the Throttler actually keeps
+// filled at rateHz.
+func NewBucketForRate(rateHz float64, window time.Duration) (*Bucket, error) {
+	capacity, err := ChooseCapacity(rateHz, window)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBucket(capacity, 0), nil
+}
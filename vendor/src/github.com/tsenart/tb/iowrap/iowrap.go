@@ -0,0 +1,149 @@
+// Package iowrap provides bandwidth-throttled wrappers around io.Reader,
+// io.Writer and http.RoundTripper, backed by a tb.Bucket. One token is
+// consumed per byte transferred, and callers block on the bucket's
+// context-aware WaitN until enough tokens are available.
+package iowrap
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/tsenart/tb"
+)
+
+// reader throttles reads from r to the rate of b, consuming one token per
+// byte returned.
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	b   *tb.Bucket
+}
+
+// NewReader returns an io.Reader that reads from r, blocking until b has
+// enough tokens available to cover the bytes returned by each Read call.
+// Each call is chunked to at most min(len(p), b.Capacity()) bytes so that a
+// single large Read doesn't stall indefinitely waiting for the bucket to
+// refill.
+func NewReader(r io.Reader, b *tb.Bucket) io.Reader {
+	return &reader{ctx: context.Background(), r: r, b: b}
+}
+
+func (rd *reader) Read(p []byte) (int, error) {
+	if max := int(rd.b.Capacity()); len(p) > max {
+		p = p[:max]
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if err := rd.b.WaitN(rd.ctx, int64(len(p))); err != nil {
+		return 0, err
+	}
+
+	n, err := rd.r.Read(p)
+	if n < len(p) {
+		rd.b.Put(int64(len(p) - n))
+	}
+
+	return n, err
+}
+
+// writer throttles writes to w to the rate of b, consuming one token per
+// byte written.
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	b   *tb.Bucket
+}
+
+// NewWriter returns an io.Writer that writes to w, blocking until b has
+// enough tokens available to cover the bytes written. Writes larger than
+// b.Capacity() are split into chunks of at most b.Capacity() bytes so a
+// single large Write doesn't stall indefinitely waiting for the bucket to
+// refill.
+func NewWriter(w io.Writer, b *tb.Bucket) io.Writer {
+	return &writer{ctx: context.Background(), w: w, b: b}
+}
+
+func (wr *writer) Write(p []byte) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		chunk := p
+		if max := int(wr.b.Capacity()); len(chunk) > max {
+			chunk = chunk[:max]
+		}
+
+		if err := wr.b.WaitN(wr.ctx, int64(len(chunk))); err != nil {
+			return written, err
+		}
+
+		n, err := wr.w.Write(chunk)
+		written += n
+
+		if n < len(chunk) {
+			wr.b.Put(int64(len(chunk) - n))
+		}
+
+		if err != nil {
+			return written, err
+		}
+
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// readCloser pairs a throttled io.Reader with the io.Closer of the body it
+// wraps, so closing an HTTP request or response body still works as usual.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// roundTripper throttles the request and response bodies of an
+// http.RoundTripper.
+type roundTripper struct {
+	rt              http.RoundTripper
+	ingress, egress *tb.Bucket
+}
+
+// NewRoundTripper returns an http.RoundTripper wrapping rt that throttles
+// the request body to egress and the response body to ingress, consuming
+// one token per byte of each transferred. Either bucket may be nil to leave
+// that direction unthrottled. If rt is nil, http.DefaultTransport is used.
+func NewRoundTripper(rt http.RoundTripper, ingress, egress *tb.Bucket) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	return &roundTripper{rt: rt, ingress: ingress, egress: egress}
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.egress != nil && req.Body != nil {
+		body := req.Body
+		req = req.Clone(req.Context())
+		req.Body = &readCloser{
+			Reader: &reader{ctx: req.Context(), r: body, b: rt.egress},
+			Closer: body,
+		}
+	}
+
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil || resp == nil || resp.Body == nil {
+		return resp, err
+	}
+
+	if rt.ingress != nil {
+		resp.Body = &readCloser{
+			Reader: &reader{ctx: req.Context(), r: resp.Body, b: rt.ingress},
+			Closer: resp.Body,
+		}
+	}
+
+	return resp, nil
+}
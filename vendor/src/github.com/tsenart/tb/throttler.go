@@ -1,18 +1,60 @@
 package tb
 
 import (
+	"context"
+	"errors"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Throttler lifecycle states, guarded by Throttler.mu alongside the
+// buckets map and the closing channel, so a state transition and the
+// channel it controls are always published as a single atomic step.
+// stateClosed is terminal: once reached, neither Start nor Stop can move
+// out of it.
+const (
+	stateNew int32 = iota
+	stateRunning
+	stateStopped
+	stateClosed
+)
+
+var (
+	// ErrAlreadyStarted is returned by Start when the filling go-routine is
+	// already running.
+	ErrAlreadyStarted = errors.New("tb: already started")
+
+	// ErrAlreadyClosed is returned by Close and Stop when the Throttler, or
+	// Bucket, has already been closed or stopped.
+	ErrAlreadyClosed = errors.New("tb: already closed")
+)
+
 // Throttler is a thread-safe wrapper around a map of buckets and an easy to
 // use API for generic throttling.
 type Throttler struct {
-	mu      sync.RWMutex
-	freq    time.Duration
-	buckets map[string]*Bucket
-	closing chan struct{}
+	mu        sync.RWMutex
+	freq      time.Duration
+	idleTTL   time.Duration
+	buckets   map[string]*Bucket
+	state     int32
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+// Option configures optional behaviour of a Throttler created by
+// NewThrottler.
+type Option func(*Throttler)
+
+// WithIdleTTL returns an Option that has the filling go-routine evict and
+// Close buckets which haven't been taken from or put into for at least d.
+// This keeps the bucket map from growing unboundedly in long-lived servers
+// with churning key spaces, e.g. per-IP or per-tenant throttling.
+func WithIdleTTL(d time.Duration) Option {
+	return func(t *Throttler) {
+		t.idleTTL = d
+	}
 }
 
 // NewThrottler returns a Throttler with a single filler go-routine for all
@@ -20,21 +62,67 @@ type Throttler struct {
 // The number of tokens added on each tick for each bucket is computed
 // dynamically to be even accross the duration of a second.
 //
-// If freq <= 0, the filling go-routine won't be started.
-func NewThrottler(freq time.Duration) *Throttler {
+// If freq <= 0, the filling go-routine won't be started and the Buckets
+// aren't filled.
+func NewThrottler(freq time.Duration, opts ...Option) *Throttler {
 	th := &Throttler{
 		freq:    freq,
 		buckets: map[string]*Bucket{},
-		closing: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(th)
 	}
 
 	if freq > 0 {
-		go th.fill(freq)
+		th.Start()
 	}
 
 	return th
 }
 
+// Start begins, or resumes, the filling go-routine without discarding the
+// existing bucket map. It returns ErrAlreadyStarted if the Throttler is
+// already running, and ErrAlreadyClosed if it has been permanently closed.
+func (t *Throttler) Start() error {
+	t.mu.Lock()
+
+	switch t.state {
+	case stateRunning:
+		t.mu.Unlock()
+		return ErrAlreadyStarted
+	case stateClosed:
+		t.mu.Unlock()
+		return ErrAlreadyClosed
+	}
+
+	t.state = stateRunning
+	closing := make(chan struct{})
+	t.closing = closing
+	t.mu.Unlock()
+
+	go t.fill(t.freq, closing)
+
+	return nil
+}
+
+// Stop pauses the filling go-routine, leaving the bucket map intact so a
+// later Start resumes with the same buckets. It returns ErrAlreadyClosed if
+// the Throttler isn't currently running.
+func (t *Throttler) Stop() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != stateRunning {
+		return ErrAlreadyClosed
+	}
+
+	t.state = stateStopped
+	close(t.closing)
+
+	return nil
+}
+
 // Bucket returns a Bucket with rate capacity, keyed by key.
 //
 // If a Bucket (key, rate) doesn't exist yet, it is created.
@@ -47,8 +135,7 @@ func (t *Throttler) Bucket(key string, rate int64) *Bucket {
 	t.mu.RUnlock()
 
 	if !ok {
-		b = NewBucket(rate, 0)
-		b.inc = int64(math.Floor(.5 + (float64(b.capacity) * t.freq.Seconds())))
+		b = NewBucket(rate, int64(math.Floor(.5+(float64(rate)*t.freq.Seconds()))))
 		t.mu.Lock()
 		t.buckets[key] = b
 		t.mu.Unlock()
@@ -57,6 +144,61 @@ func (t *Throttler) Bucket(key string, rate int64) *Bucket {
 	return b
 }
 
+// AddBucketForRate creates, under key, a Bucket sized by ChooseCapacity for
+// rateHz over window, and registers it so the Throttler's own filling
+// go-routine keeps it topped up at rateHz. Unlike Bucket, it decouples the
+// Bucket's burst capacity from its average fill rate, so rateHz needn't
+// equal the capacity the caller would otherwise have to pick by hand. It
+// overwrites any existing Bucket already registered under key.
+//
+// You must call Close when you're done with the Throttler in order to not leak
+// a go-routine and a system-timer.
+func (t *Throttler) AddBucketForRate(key string, rateHz float64, window time.Duration) (*Bucket, error) {
+	capacity, err := ChooseCapacity(rateHz, window)
+	if err != nil {
+		return nil, err
+	}
+
+	b := NewBucket(capacity, int64(math.Floor(.5+(rateHz*t.freq.Seconds()))))
+
+	t.mu.Lock()
+	t.buckets[key] = b
+	t.mu.Unlock()
+
+	return b, nil
+}
+
+// SetRate atomically updates the rate of the Bucket identified by key,
+// recomputing its fill increment and resizing its capacity to match. Unlike
+// Bucket, it has no effect if the Bucket doesn't exist yet, since there is
+// no existing quota to change.
+func (t *Throttler) SetRate(key string, rate int64) {
+	t.mu.RLock()
+	b, ok := t.buckets[key]
+	t.mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	b.resize(rate, int64(math.Floor(.5+(float64(rate)*t.freq.Seconds()))))
+}
+
+// Remove drops the Bucket identified by key from the Throttler and closes
+// it. It has no effect if the Bucket doesn't exist.
+func (t *Throttler) Remove(key string) {
+	t.mu.Lock()
+	b, ok := t.buckets[key]
+	if ok {
+		delete(t.buckets, key)
+	}
+	t.mu.Unlock()
+
+	if ok {
+		b.Close()
+	}
+}
+
 // Wait waits for n amount of tokens to be available, sleeping freq between each
 // take. It returns the wait duration and whether it had to wait or not.
 //
@@ -85,6 +227,54 @@ func (t *Throttler) Wait(key string, n, rate int64) (time.Duration, bool) {
 	return time.Since(began), true
 }
 
+// Capacity returns the capacity of the Bucket identified by key and rate,
+// so that callers can validate n <= capacity before calling Wait or
+// WaitContext. Both calls accept a larger n, collecting it over several
+// fills rather than in a single take.
+//
+// If a Bucket (key, rate) doesn't exist yet, it is created.
+func (t *Throttler) Capacity(key string, rate int64) int64 {
+	return t.Bucket(key, rate).Capacity()
+}
+
+// WaitContext behaves like Wait but aborts early with ctx.Err() if ctx is
+// cancelled or its deadline expires while waiting. Tokens already taken are
+// put back into the bucket so they aren't lost on abort.
+//
+// If a Bucket (key, rate) doesn't exist yet, it is created.
+// If freq < 1/rate seconds, the effective wait rate won't be correct.
+//
+// You must call Close when you're done with the Throttler in order to not leak
+// a go-routine and a system-timer.
+func (t *Throttler) WaitContext(ctx context.Context, key string, n, rate int64) (time.Duration, error) {
+	var (
+		got   int64
+		began = time.Now()
+	)
+
+	b := t.Bucket(key, rate)
+
+	if got = b.Take(n); got == n {
+		return time.Since(began), nil
+	}
+
+	timer := time.NewTimer(t.freq)
+	defer timer.Stop()
+
+	for got < n {
+		select {
+		case <-ctx.Done():
+			b.Put(got)
+			return time.Since(began), ctx.Err()
+		case <-timer.C:
+			got += b.Take(n - got)
+			timer.Reset(t.freq)
+		}
+	}
+
+	return time.Since(began), nil
+}
+
 // Halt returns a bool indicating if the Bucket identified by key and rate has
 // n amount of tokens. If it doesn't, the taken tokens are added back to the
 // bucket.
@@ -105,34 +295,50 @@ func (t *Throttler) Halt(key string, n, rate int64) bool {
 	return false
 }
 
-// Close stops filling the Buckets, closing the filling go-routine.
+// Close permanently stops filling the Buckets and closes them all. It's
+// safe to call multiple times; subsequent calls return ErrAlreadyClosed.
+// A closed Throttler cannot be Start-ed again.
 func (t *Throttler) Close() error {
-	close(t.closing)
+	err := ErrAlreadyClosed
 
-	t.mu.RLock()
-	defer t.mu.RUnlock()
+	t.closeOnce.Do(func() {
+		err = nil
 
-	for _, b := range t.buckets {
-		b.Close()
-	}
+		t.mu.Lock()
+		defer t.mu.Unlock()
 
-	return nil
+		if t.state == stateRunning {
+			close(t.closing)
+		}
+		t.state = stateClosed
+
+		for _, b := range t.buckets {
+			b.Close()
+		}
+	})
+
+	return err
 }
 
-func (t *Throttler) fill(freq time.Duration) {
+func (t *Throttler) fill(freq time.Duration, closing chan struct{}) {
 	ticker := time.NewTicker(freq)
 	defer ticker.Stop()
 
-	for _ = range ticker.C {
+	for now := range ticker.C {
 		select {
-		case <-t.closing:
+		case <-closing:
 			return
 		default:
 		}
-		t.mu.RLock()
-		for _, b := range t.buckets {
-			b.Put(b.inc)
+		t.mu.Lock()
+		for key, b := range t.buckets {
+			if t.idleTTL > 0 && b.idleSince(now) >= t.idleTTL {
+				delete(t.buckets, key)
+				b.Close()
+				continue
+			}
+			b.add(atomic.LoadInt64(&b.inc))
 		}
-		t.mu.RUnlock()
+		t.mu.Unlock()
 	}
 }